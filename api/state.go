@@ -0,0 +1,261 @@
+// Package api serves the cached device state over HTTP so consumers don't
+// need to talk to REDIS or know the key scheme.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/juju/loggo"
+)
+
+var (
+	log = loggo.GetLogger("state-service.api")
+
+	// matches the user, device and channel ids accepted in routing keys
+	idRegex = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+)
+
+// StateServer handles GET /state/{user_id}[/{device_id}[/{channel_id}]]
+type StateServer struct {
+	pool  *redis.Pool
+	token string
+}
+
+// NewStateServer returns a server reading from pool, if token is set requests
+// must supply it as a bearer token.
+func NewStateServer(pool *redis.Pool, token string) *StateServer {
+	return &StateServer{
+		pool:  pool,
+		token: token,
+	}
+}
+
+func (ss *StateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !ss.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ids, ok := parsePath(r.URL.Path)
+
+	if !ok {
+		http.Error(w, "bad state path", http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	var err error
+
+	switch len(ids) {
+	case 3:
+		result, err = ss.getChannel(ids[0], ids[1], ids[2])
+	case 2:
+		result, err = ss.getDevice(ids[0], ids[1])
+	default:
+		result, err = ss.getUser(ids[0])
+	}
+
+	if err != nil {
+		log.Errorf("failed to read state %v: %s", ids, err)
+		http.Error(w, "failed to read state", http.StatusInternalServerError)
+		return
+	}
+
+	if result == nil {
+		http.Error(w, "state not found", http.StatusNotFound)
+		return
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+func (ss *StateServer) authorized(r *http.Request) bool {
+
+	if ss.token == "" {
+		return true
+	}
+
+	expected := "Bearer " + ss.token
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+// parsePath splits /state/{user_id}/{device_id}/{channel_id} into its ids.
+func parsePath(path string) ([]string, bool) {
+
+	if !strings.HasPrefix(path, "/state/") {
+		return nil, false
+	}
+
+	ids := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/state/"), "/"), "/")
+
+	if len(ids) > 3 {
+		return nil, false
+	}
+
+	for _, id := range ids {
+		if !idRegex.MatchString(id) {
+			return nil, false
+		}
+	}
+
+	return ids, true
+}
+
+func (ss *StateServer) getChannel(userID, deviceID, channelID string) (interface{}, error) {
+
+	c := ss.pool.Get()
+	defer c.Close()
+
+	value, err := redis.Bytes(c.Do("GET", fmt.Sprintf("state:%s:%s:%s", userID, deviceID, channelID)))
+
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rawPayload(value), nil
+}
+
+// getDevice returns the state of each channel keyed by channel id.
+func (ss *StateServer) getDevice(userID, deviceID string) (interface{}, error) {
+
+	state, err := ss.scanState(fmt.Sprintf("state:%s:%s:*", userID, deviceID))
+
+	if err != nil || len(state) == 0 {
+		return nil, err
+	}
+
+	channels := make(map[string]interface{})
+
+	for key, value := range state {
+		parts := strings.Split(key, ":")
+		channels[parts[3]] = value
+	}
+
+	return channels, nil
+}
+
+// getUser returns the state of each device keyed by device id then channel id.
+func (ss *StateServer) getUser(userID string) (interface{}, error) {
+
+	state, err := ss.scanState(fmt.Sprintf("state:%s:*:*", userID))
+
+	if err != nil || len(state) == 0 {
+		return nil, err
+	}
+
+	devices := make(map[string]map[string]interface{})
+
+	for key, value := range state {
+		parts := strings.Split(key, ":")
+
+		if devices[parts[2]] == nil {
+			devices[parts[2]] = make(map[string]interface{})
+		}
+		devices[parts[2]][parts[3]] = value
+	}
+
+	return devices, nil
+}
+
+// scanState finds the keys matching pattern with SCAN then loads them with MGET.
+func (ss *StateServer) scanState(pattern string) (map[string]interface{}, error) {
+
+	c := ss.pool.Get()
+	defer c.Close()
+
+	keys := []string{}
+	cursor := 0
+
+	for {
+		values, err := redis.Values(c.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(values) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %v", values)
+		}
+
+		if cursor, err = redis.Int(values[0], nil); err != nil {
+			return nil, err
+		}
+
+		found, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range found {
+			// skip keys where an id contains the separator
+			if strings.Count(key, ":") == 3 {
+				keys = append(keys, key)
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	state := make(map[string]interface{})
+
+	if len(keys) == 0 {
+		return state, nil
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	values, err := redis.Values(c.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		// the key may have expired or been removed since the SCAN
+		if b, ok := value.([]byte); ok {
+			state[keys[i]] = rawPayload(b)
+		}
+	}
+
+	return state, nil
+}
+
+// rawPayload passes stored JSON through untouched, anything else is returned
+// as a string.
+func rawPayload(value []byte) interface{} {
+
+	var raw json.RawMessage
+
+	if err := json.Unmarshal(value, &raw); err != nil {
+		return string(value)
+	}
+
+	return raw
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// fakeConn answers GET, SCAN and MGET from a map the way REDIS would.
+type fakeConn struct {
+	redis.Conn
+	state map[string]string
+}
+
+func (fc *fakeConn) Close() error { return nil }
+func (fc *fakeConn) Err() error   { return nil }
+
+func (fc *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "GET":
+		if args[0].(string) == "state:123:7511a8ecc5:broken" {
+			return nil, errors.New("ERR internal redis detail")
+		}
+		return fc.get(args[0].(string)), nil
+	case "SCAN":
+		keys := []interface{}{}
+		for key := range fc.state {
+			if ok, _ := path.Match(args[2].(string), key); ok {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	case "MGET":
+		values := []interface{}{}
+		for _, key := range args {
+			values = append(values, fc.get(key.(string)))
+		}
+		return values, nil
+	}
+	return nil, nil
+}
+
+func (fc *fakeConn) get(key string) interface{} {
+	if value, ok := fc.state[key]; ok {
+		return []byte(value)
+	}
+	return nil
+}
+
+func newTestServer(token string) *StateServer {
+
+	fc := &fakeConn{state: map[string]string{
+		"state:123:7511a8ecc5:volume": `{"params":[{"level":0.19,"muted":false}]}`,
+		"state:123:7511a8ecc5:media":  `{"params":[{"position":239000}]}`,
+		"state:123:b6b984190f:on-off": `{"params":[true]}`,
+		"state:456:b6b984190f:on-off": `{"params":[false]}`,
+	}}
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return fc, nil
+		},
+	}
+
+	return NewStateServer(pool, token)
+}
+
+func get(ss *StateServer, url, token string) *httptest.ResponseRecorder {
+
+	r, _ := http.NewRequest("GET", url, nil)
+
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	ss.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestGetState(t *testing.T) {
+
+	ss := newTestServer("")
+
+	w := get(ss, "/state/123/7511a8ecc5/volume", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"params":[{"level":0.19,"muted":false}]}` {
+		t.Errorf("bad channel state %d %s", w.Code, w.Body)
+	}
+
+	w = get(ss, "/state/123/7511a8ecc5", "")
+	channels := map[string]json.RawMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &channels); err != nil || len(channels) != 2 {
+		t.Errorf("bad device state %d %s", w.Code, w.Body)
+	}
+
+	w = get(ss, "/state/123/", "")
+	devices := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &devices); err != nil || len(devices) != 2 || len(devices["7511a8ecc5"]) != 2 {
+		t.Errorf("bad user state %d %s", w.Code, w.Body)
+	}
+
+	if string(devices["b6b984190f"]["on-off"]) != `{"params":[true]}` {
+		t.Errorf("bad user state %s", w.Body)
+	}
+}
+
+func TestGetStateErrors(t *testing.T) {
+
+	ss := newTestServer("secret")
+
+	expected := map[string]int{
+		"/state/123/7511a8ecc5/volume":   http.StatusUnauthorized,
+		"/state/123/7511a8ecc5/missing":  http.StatusNotFound,
+		"/state/789":                     http.StatusNotFound,
+		"/state/":                        http.StatusBadRequest,
+		"/state/123/*":                   http.StatusBadRequest,
+		"/state/123/7511a8ecc5/volume/x": http.StatusBadRequest,
+	}
+
+	for url, code := range expected {
+
+		token := "secret"
+		if code == http.StatusUnauthorized {
+			token = "wrong"
+		}
+
+		if w := get(ss, url, token); w.Code != code {
+			t.Errorf("%s returned %d expected %d", url, w.Code, code)
+		}
+	}
+}
+
+func TestGetStateHidesRedisErrors(t *testing.T) {
+
+	w := get(newTestServer(""), "/state/123/7511a8ecc5/broken", "")
+
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "redis") {
+		t.Errorf("bad error response %d %s", w.Code, w.Body)
+	}
+}
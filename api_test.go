@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func stateAPIStatus(mux *http.ServeMux, token string) int {
+
+	r, _ := http.NewRequest("GET", "/state/123", nil)
+
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestMountStateAPI(t *testing.T) {
+
+	// REDIS is never reached by a request which is turned away
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return nil, errors.New("no redis")
+		},
+	}
+
+	// no token and no separate address leaves the status listener alone
+	status := http.NewServeMux()
+
+	if mux := mountStateAPI(status, pool, "", ""); mux != nil {
+		t.Errorf("unexpected api listener")
+	}
+
+	if code := stateAPIStatus(status, ""); code != http.StatusNotFound {
+		t.Errorf("state api served on status listener without token %d", code)
+	}
+
+	// a token mounts the api on the status listener behind auth
+	status = http.NewServeMux()
+
+	if mux := mountStateAPI(status, pool, "", "secret"); mux != nil {
+		t.Errorf("unexpected api listener")
+	}
+
+	if code := stateAPIStatus(status, ""); code != http.StatusUnauthorized {
+		t.Errorf("state api on status listener allowed request without token %d", code)
+	}
+
+	// a separate address gets its own mux and the status listener is untouched
+	status = http.NewServeMux()
+
+	mux := mountStateAPI(status, pool, ":6101", "secret")
+
+	if mux == nil {
+		t.Fatalf("expected api listener")
+	}
+
+	if code := stateAPIStatus(status, "secret"); code != http.StatusNotFound {
+		t.Errorf("state api served on status listener with separate address %d", code)
+	}
+
+	if code := stateAPIStatus(mux, ""); code != http.StatusUnauthorized {
+		t.Errorf("state api allowed request without token %d", code)
+	}
+}
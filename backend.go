@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// backend is where the state service caches state payloads.
+type backend interface {
+	Set(key, value string) error
+}
+
+type redisBackend struct {
+	pool *redis.Pool
+}
+
+func (rb *redisBackend) Set(key, value string) error {
+
+	c := rb.pool.Get()
+	defer c.Close()
+
+	n, err := c.Do("SET", key, value)
+
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("redis key = %s n = %v", key, n)
+
+	return nil
+}
+
+// memoryBackend keeps state in a map, used when simulating.
+type memoryBackend struct {
+	sync.Mutex
+	state map[string]string
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		state: make(map[string]string),
+	}
+}
+
+func (mb *memoryBackend) Set(key, value string) error {
+	mb.Lock()
+	defer mb.Unlock()
+
+	mb.state[key] = value
+
+	return nil
+}
+
+// Keys returns the stored keys in sorted order.
+func (mb *memoryBackend) Keys() []string {
+	mb.Lock()
+	defer mb.Unlock()
+
+	keys := make([]string, 0, len(mb.state))
+
+	for key := range mb.state {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (mb *memoryBackend) Get(key string) (string, bool) {
+	mb.Lock()
+	defer mb.Unlock()
+
+	value, ok := mb.state[key]
+
+	return value, ok
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+	"github.com/wolfeidau/punter"
+)
+
+// queueConsumer is satisfied by punter.Consumer and qosConsumer.
+type queueConsumer interface {
+	Shutdown() error
+}
+
+// qosConsumer sets up the queue the same way as punter.Consumer but keeps
+// hold of the channel so the prefetch can be changed while consuming.
+type qosConsumer struct {
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	tag     string
+	done    chan error
+}
+
+func newQosConsumer(conf *punter.Config, ctag string, prefetch int, handler func(<-chan amqp.Delivery, chan error)) (*qosConsumer, error) {
+
+	c := &qosConsumer{
+		tag:  ctag,
+		done: make(chan error),
+	}
+
+	var err error
+
+	c.conn, err = amqp.Dial(conf.AmqpURI)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %s", err)
+	}
+
+	c.channel, err = c.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("channel: %s", err)
+	}
+
+	// RabbitMQ applies a non global prefetch to consumers created after it is
+	// set, global applies it to the channel which only has this consumer, so
+	// the prefetch can be changed while consuming.
+	if err = c.channel.Qos(prefetch, 0, true); err != nil {
+		return nil, fmt.Errorf("qos: %s", err)
+	}
+
+	queue, err := c.channel.QueueDeclare(
+		conf.QueueName,
+		conf.Durable,
+		false, // delete when unused
+		false, // exclusive
+		false, // noWait
+		amqp.Table{"x-message-ttl": conf.MessageTTL},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("queue declare: %s", err)
+	}
+
+	if err = c.channel.QueueBind(queue.Name, conf.Key, conf.Exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("queue bind: %s", err)
+	}
+
+	deliveries, err := c.channel.Consume(queue.Name, c.tag, false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("queue consume: %s", err)
+	}
+
+	go handler(deliveries, c.done)
+
+	return c, nil
+}
+
+// Qos changes the prefetch of the channel.
+func (c *qosConsumer) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return c.channel.Qos(prefetchCount, prefetchSize, global)
+}
+
+func (c *qosConsumer) Shutdown() error {
+
+	if err := c.channel.Cancel(c.tag, true); err != nil {
+		return fmt.Errorf("consumer cancel failed: %s", err)
+	}
+
+	if err := c.conn.Close(); err != nil {
+		return fmt.Errorf("AMQP connection close error: %s", err)
+	}
+
+	// wait for the handler to exit
+	return <-c.done
+}
@@ -0,0 +1,202 @@
+// Package corpus reads and writes recorded AMQP deliveries so that real
+// traffic can be replayed against a build with the simulate command.
+package corpus
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Properties holds the AMQP message properties of a delivery. Header values
+// are stored as JSON so numeric types are not preserved on replay.
+type Properties struct {
+	Headers         amqp.Table `json:"headers,omitempty"`
+	ContentType     string     `json:"content_type,omitempty"`
+	ContentEncoding string     `json:"content_encoding,omitempty"`
+	DeliveryMode    uint8      `json:"delivery_mode,omitempty"`
+	Priority        uint8      `json:"priority,omitempty"`
+	CorrelationId   string     `json:"correlation_id,omitempty"`
+	ReplyTo         string     `json:"reply_to,omitempty"`
+	Expiration      string     `json:"expiration,omitempty"`
+	MessageId       string     `json:"message_id,omitempty"`
+	Timestamp       time.Time  `json:"timestamp"`
+	Type            string     `json:"type,omitempty"`
+	UserId          string     `json:"user_id,omitempty"`
+	AppId           string     `json:"app_id,omitempty"`
+}
+
+// Record is a single captured delivery, stored one per line as JSON.
+type Record struct {
+	Time        time.Time  `json:"time"`
+	Exchange    string     `json:"exchange"`
+	RoutingKey  string     `json:"routing_key"`
+	Redelivered bool       `json:"redelivered"`
+	Origin      string     `json:"origin,omitempty"`
+	Properties  Properties `json:"properties"`
+	BodySize    int        `json:"body_size"`
+	BodyHash    string     `json:"body_sha256"`
+	Redacted    bool       `json:"redacted,omitempty"`
+	Body        []byte     `json:"body,omitempty"`
+}
+
+// NewRecord captures the delivery along with the origin of the write, if
+// redact is set only the hash and size of the payload are kept.
+func NewRecord(d amqp.Delivery, origin string, redact bool) *Record {
+	rec := &Record{
+		Time:        time.Now(),
+		Exchange:    d.Exchange,
+		RoutingKey:  d.RoutingKey,
+		Redelivered: d.Redelivered,
+		Origin:      origin,
+		Properties: Properties{
+			Headers:         d.Headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    d.DeliveryMode,
+			Priority:        d.Priority,
+			CorrelationId:   d.CorrelationId,
+			ReplyTo:         d.ReplyTo,
+			Expiration:      d.Expiration,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			UserId:          d.UserId,
+			AppId:           d.AppId,
+		},
+		BodySize: len(d.Body),
+		BodyHash: Hash(d.Body),
+		Redacted: redact,
+	}
+
+	if !redact {
+		rec.Body = d.Body
+	}
+
+	return rec
+}
+
+// Delivery rebuilds the delivery from the record, redacted payloads are
+// replaced with filler of the original size. An error is returned if the
+// payload doesn't match the recorded size and hash.
+func (rec *Record) Delivery() (amqp.Delivery, error) {
+
+	body := rec.Body
+
+	if rec.Redacted {
+		body = bytes.Repeat([]byte("x"), rec.BodySize)
+	} else if len(body) != rec.BodySize || Hash(body) != rec.BodyHash {
+		return amqp.Delivery{}, fmt.Errorf("payload does not match recorded size %dB and hash %.12s", rec.BodySize, rec.BodyHash)
+	}
+
+	p := rec.Properties
+
+	return amqp.Delivery{
+		Exchange:        rec.Exchange,
+		RoutingKey:      rec.RoutingKey,
+		Redelivered:     rec.Redelivered,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		Body:            body,
+	}, nil
+}
+
+// Hash returns the hex encoded sha256 of the payload.
+func Hash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Writer appends records to a corpus, it is safe for use by multiple workers.
+type Writer struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	redact bool
+}
+
+func NewWriter(w io.Writer, redact bool) *Writer {
+	return &Writer{
+		enc:    json.NewEncoder(w),
+		redact: redact,
+	}
+}
+
+func (w *Writer) Write(d amqp.Delivery, origin string) error {
+	rec := NewRecord(d, origin, w.redact)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.enc.Encode(rec)
+}
+
+// BadRecordError is returned by Next for a line which can't be decoded, the
+// reader can carry on with the following line.
+type BadRecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *BadRecordError) Error() string {
+	return fmt.Sprintf("bad record on line %d: %s", e.Line, e.Err)
+}
+
+// Reader returns records from a corpus in the order they were captured.
+type Reader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next record or io.EOF once the corpus is exhausted.
+func (r *Reader) Next() (*Record, error) {
+
+	for {
+		line, err := r.r.ReadBytes('\n')
+
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		r.line++
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		rec := &Record{}
+
+		if derr := json.Unmarshal(line, rec); derr != nil {
+			return nil, &BadRecordError{Line: r.line, Err: derr}
+		}
+
+		return rec, nil
+	}
+}
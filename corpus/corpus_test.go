@@ -0,0 +1,106 @@
+package corpus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+
+	d := amqp.Delivery{
+		Exchange:      "amq.topic",
+		RoutingKey:    "123.$cloud.device.7511a8ecc5.channel.volume.event.state",
+		Redelivered:   true,
+		Headers:       amqp.Table{"source": "sphere"},
+		ContentType:   "application/json",
+		DeliveryMode:  2,
+		CorrelationId: "abc",
+		Timestamp:     time.Unix(1422501653, 0).UTC(),
+		Type:          "state",
+		AppId:         "driver-sonos",
+		Body:          []byte(`{"params":[{"level":0.19}]}`),
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := NewWriter(buf, false).Write(d, "redelivered"); err != nil {
+		t.Fatalf("failed to write record %s", err)
+	}
+
+	rec, err := NewReader(buf).Next()
+	if err != nil {
+		t.Fatalf("failed to read record %s", err)
+	}
+
+	replay, err := rec.Delivery()
+	if err != nil {
+		t.Fatalf("failed to rebuild delivery %s", err)
+	}
+
+	if rec.Origin != "redelivered" {
+		t.Errorf("bad origin %s", rec.Origin)
+	}
+
+	if replay.RoutingKey != d.RoutingKey || !replay.Redelivered || string(replay.Body) != string(d.Body) {
+		t.Errorf("bad delivery %+v", replay)
+	}
+
+	if replay.Headers["source"] != "sphere" || replay.ContentType != d.ContentType || replay.DeliveryMode != 2 ||
+		replay.CorrelationId != "abc" || !replay.Timestamp.Equal(d.Timestamp) || replay.Type != "state" || replay.AppId != "driver-sonos" {
+		t.Errorf("bad properties %+v", replay)
+	}
+}
+
+func TestRedactedRecord(t *testing.T) {
+
+	d := amqp.Delivery{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{"secret":true}`)}
+
+	rec := NewRecord(d, "live", true)
+
+	if rec.Body != nil {
+		t.Errorf("payload not redacted %s", rec.Body)
+	}
+
+	if rec.BodyHash != Hash(d.Body) {
+		t.Errorf("bad hash %s", rec.BodyHash)
+	}
+
+	replay, err := rec.Delivery()
+	if err != nil {
+		t.Fatalf("failed to rebuild delivery %s", err)
+	}
+
+	if len(replay.Body) != len(d.Body) {
+		t.Errorf("bad replay size %d", len(replay.Body))
+	}
+}
+
+func TestBadRecords(t *testing.T) {
+
+	rec := NewRecord(amqp.Delivery{Body: []byte(`{}`)}, "live", false)
+	rec.Body = []byte(`{"tampered":true}`)
+
+	if _, err := rec.Delivery(); err == nil {
+		t.Errorf("expected error rebuilding tampered record")
+	}
+
+	r := NewReader(strings.NewReader("{\"routing_key\":\"a\"}\nnot json\n\n{\"routing_key\":\"b\"}\n"))
+
+	if rec, err := r.Next(); err != nil || rec.RoutingKey != "a" {
+		t.Errorf("bad first record %v %v", rec, err)
+	}
+
+	if _, err := r.Next(); err == nil {
+		t.Errorf("expected bad record error")
+	} else if berr, ok := err.(*BadRecordError); !ok || berr.Line != 2 {
+		t.Errorf("bad error %v", err)
+	}
+
+	if rec, err := r.Next(); err != nil || rec.RoutingKey != "b" {
+		t.Errorf("bad record after bad line %v %v", rec, err)
+	}
+}
@@ -3,15 +3,41 @@ package health
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
 )
 
+var (
+	statusFuncsMu sync.Mutex
+	statusFuncs   = make(map[string]func() string)
+)
+
+// AddStatusFunc adds a value to the status payload which is evaluated on each request.
+func AddStatusFunc(name string, fn func() string) {
+	statusFuncsMu.Lock()
+	defer statusFuncsMu.Unlock()
+
+	statusFuncs[name] = fn
+}
+
 type statusServer struct {
 	statusInfo map[string]string
 }
 
 func (ss *statusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 
-	payload, err := json.Marshal(ss.statusInfo)
+	status := make(map[string]string)
+
+	for k, v := range ss.statusInfo {
+		status[k] = v
+	}
+
+	statusFuncsMu.Lock()
+	for k, fn := range statusFuncs {
+		status[k] = fn()
+	}
+	statusFuncsMu.Unlock()
+
+	payload, err := json.Marshal(status)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
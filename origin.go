@@ -0,0 +1,20 @@
+package main
+
+import "github.com/streadway/amqp"
+
+// origin records which path produced a write.
+type origin string
+
+const (
+	originLive        origin = "live"
+	originRedelivered origin = "redelivered"
+	originReplayed    origin = "replayed"
+)
+
+// deliveryOrigin derives the origin of a write from the delivery metadata.
+func deliveryOrigin(d amqp.Delivery) origin {
+	if d.Redelivered {
+		return originRedelivered
+	}
+	return originLive
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ninjablocks/sphere-go-state-service/corpus"
+	"github.com/streadway/amqp"
+)
+
+func TestDeliveryOrigin(t *testing.T) {
+
+	if o := deliveryOrigin(amqp.Delivery{}); o != originLive {
+		t.Errorf("bad origin for live delivery %s", o)
+	}
+
+	if o := deliveryOrigin(amqp.Delivery{Redelivered: true}); o != originRedelivered {
+		t.Errorf("bad origin for redelivery %s", o)
+	}
+}
+
+// readOrigins returns the origin of each record in the corpus.
+func readOrigins(buf *bytes.Buffer) []string {
+
+	origins := []string{}
+
+	r := corpus.NewReader(buf)
+
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			break
+		}
+		origins = append(origins, rec.Origin)
+	}
+
+	return origins
+}
+
+func TestConsumerOrigin(t *testing.T) {
+
+	captured := &bytes.Buffer{}
+
+	ss, _ := newSimulateStore()
+	ss.capture = corpus.NewWriter(captured, false)
+
+	deliveries := make(chan amqp.Delivery, 2)
+	deliveries <- amqp.Delivery{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{}`)}
+	deliveries <- amqp.Delivery{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{}`), Redelivered: true}
+	close(deliveries)
+
+	done := make(chan error, 1)
+	ss.stateHandler(nil)(deliveries, done)
+	<-done
+
+	origins := readOrigins(captured)
+
+	if len(origins) != 2 || origins[0] != string(originLive) || origins[1] != string(originRedelivered) {
+		t.Errorf("bad consumer origins %v", origins)
+	}
+}
+
+func TestSimulateOrigin(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+
+	w := corpus.NewWriter(buf, false)
+
+	for _, d := range []amqp.Delivery{
+		{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{}`)},
+		{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{}`), Redelivered: true},
+	} {
+		if err := w.Write(d, string(deliveryOrigin(d))); err != nil {
+			t.Fatalf("failed to write record %s", err)
+		}
+	}
+
+	recaptured := &bytes.Buffer{}
+
+	ss, mem := newSimulateStore()
+	ss.capture = corpus.NewWriter(recaptured, false)
+
+	out := &bytes.Buffer{}
+
+	if err := simulate(corpus.NewReader(buf), ss, mem, 0, out); err != nil {
+		t.Fatalf("simulate failed %s", err)
+	}
+
+	origins := readOrigins(recaptured)
+
+	if len(origins) != 2 || origins[0] != string(originReplayed) || origins[1] != string(originReplayed) {
+		t.Errorf("bad simulate origins %v", origins)
+	}
+
+	if !bytes.Contains(out.Bytes(), []byte("origin=redelivered")) {
+		t.Errorf("report missing recorded origin\n%s", out)
+	}
+}
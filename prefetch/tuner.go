@@ -0,0 +1,165 @@
+// Package prefetch adjusts the QoS of a consumer channel so that the
+// messages held by the consumer can be processed within a time budget.
+package prefetch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/loggo"
+	gmetrics "github.com/rcrowley/go-metrics"
+)
+
+var log = loggo.GetLogger("state-service.prefetch")
+
+// Qoser is implemented by amqp.Channel, the prefetch is applied globally as a
+// per consumer prefetch doesn't change for consumers which are already running.
+type Qoser interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+}
+
+// Tuner tracks the per message processing latency of a consumer and
+// derives a prefetch of roughly target / latency, clamped between min and max.
+type Tuner struct {
+	sync.Mutex
+
+	name     string
+	target   time.Duration
+	min, max int
+
+	current int
+	latency time.Duration // smoothed per message latency
+	total   time.Duration // latency observed since the last adjustment
+	count   int64
+
+	qos   Qoser
+	Gauge gmetrics.Gauge
+}
+
+func NewTuner(name string, target time.Duration, min, max, initial int) *Tuner {
+
+	t := &Tuner{
+		name:   name,
+		target: target,
+		min:    min,
+		max:    max,
+		Gauge:  gmetrics.NewGauge(),
+	}
+
+	t.current = t.clamp(initial)
+	t.Gauge.Update(int64(t.current))
+
+	return t
+}
+
+// Observe records the time taken to process a single message.
+func (t *Tuner) Observe(d time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.total += d
+	t.count++
+}
+
+// Prefetch returns the prefetch currently applied to the channel.
+func (t *Tuner) Prefetch() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.current
+}
+
+// Adjust folds the latency observed since the last call into the average and
+// re-issues Qos on the channel if the computed prefetch changed materially.
+func (t *Tuner) Adjust() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.count == 0 {
+		return nil
+	}
+
+	mean := t.total / time.Duration(t.count)
+	t.total, t.count = 0, 0
+
+	if t.latency == 0 {
+		t.latency = mean
+	} else {
+		t.latency = (t.latency + mean) / 2
+	}
+
+	next := t.compute(t.latency)
+
+	if !t.material(next) {
+		return nil
+	}
+
+	if t.qos != nil {
+		if err := t.qos.Qos(next, 0, true); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("%s prefetch changed from %d to %d, latency %s per message", t.name, t.current, next, t.latency)
+
+	t.current = next
+	t.Gauge.Update(int64(next))
+
+	return nil
+}
+
+// Start periodically adjusts the prefetch of the channel.
+func (t *Tuner) Start(qos Qoser, interval time.Duration) {
+
+	t.Lock()
+	t.qos = qos
+	t.Unlock()
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for _ = range ticker.C {
+			if err := t.Adjust(); err != nil {
+				log.Errorf("%s failed to adjust prefetch: %s", t.name, err)
+			}
+		}
+	}()
+}
+
+func (t *Tuner) compute(latency time.Duration) int {
+
+	if latency <= 0 {
+		return t.max
+	}
+
+	return t.clamp(int(t.target / latency))
+}
+
+// material ignores changes of less than 20%, this stops the channel being
+// re-issued Qos for every bit of jitter.
+func (t *Tuner) material(next int) bool {
+
+	diff := next - t.current
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff != 0 && diff*5 >= t.current
+}
+
+// clamp never returns 0 as that would remove the limit altogether.
+func (t *Tuner) clamp(prefetch int) int {
+
+	if prefetch < t.min {
+		prefetch = t.min
+	}
+
+	if prefetch < 1 {
+		return 1
+	}
+
+	if prefetch > t.max {
+		return t.max
+	}
+
+	return prefetch
+}
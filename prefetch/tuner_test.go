@@ -0,0 +1,151 @@
+package prefetch
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeChannel struct {
+	calls  []int
+	global []bool
+}
+
+func (fc *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	fc.calls = append(fc.calls, prefetchCount)
+	fc.global = append(fc.global, global)
+	return nil
+}
+
+// TestTunerLatencyShifts shifts the backend latency by an order of magnitude
+// in both directions and checks the prefetch settles without flapping.
+func TestTunerLatencyShifts(t *testing.T) {
+
+	fc := &fakeChannel{}
+
+	tuner := NewTuner("test", time.Second, 1, 1000, 0)
+	tuner.qos = fc
+
+	phases := []struct {
+		latency  time.Duration
+		expected int
+	}{
+		{10 * time.Millisecond, 100},
+		{100 * time.Millisecond, 10},
+		{10 * time.Millisecond, 100},
+		{time.Millisecond, 1000},
+		{10 * time.Millisecond, 100},
+	}
+
+	// +/- 10% jitter on each message
+	jitter := []float64{1.0, 1.1, 0.9, 1.05, 0.95}
+
+	for _, phase := range phases {
+
+		for interval := 0; interval < 30; interval++ {
+
+			if interval == 10 {
+				fc.calls = nil
+			}
+
+			for n := 0; n < 50; n++ {
+				tuner.Observe(time.Duration(float64(phase.latency) * jitter[n%len(jitter)]))
+			}
+
+			if err := tuner.Adjust(); err != nil {
+				t.Fatalf("adjust failed %s", err)
+			}
+		}
+
+		if len(fc.calls) != 0 {
+			t.Errorf("prefetch still changing after settling at %s latency %v", phase.latency, fc.calls)
+		}
+
+		prefetch := tuner.Prefetch()
+
+		if prefetch < phase.expected*3/4 || prefetch > phase.expected*5/4 {
+			t.Errorf("bad prefetch %d for %s latency, expected about %d", prefetch, phase.latency, phase.expected)
+		}
+
+		if tuner.Gauge.Value() != int64(prefetch) {
+			t.Errorf("gauge %d does not match prefetch %d", tuner.Gauge.Value(), prefetch)
+		}
+	}
+}
+
+func TestTunerIdle(t *testing.T) {
+
+	fc := &fakeChannel{}
+
+	tuner := NewTuner("test", time.Second, 5, 1000, 50)
+	tuner.qos = fc
+
+	if err := tuner.Adjust(); err != nil {
+		t.Fatalf("adjust failed %s", err)
+	}
+
+	if len(fc.calls) != 0 || tuner.Prefetch() != 50 {
+		t.Errorf("prefetch changed without any observations %v", fc.calls)
+	}
+}
+
+// TestTunerGlobalQos checks the prefetch is applied to the whole channel, a
+// per consumer prefetch would not change the running consumer.
+func TestTunerGlobalQos(t *testing.T) {
+
+	fc := &fakeChannel{}
+
+	tuner := NewTuner("test", time.Second, 1, 1000, 10)
+	tuner.qos = fc
+
+	tuner.Observe(10 * time.Millisecond)
+
+	if err := tuner.Adjust(); err != nil {
+		t.Fatalf("adjust failed %s", err)
+	}
+
+	if len(fc.calls) != 1 || fc.calls[0] != 100 {
+		t.Fatalf("bad qos calls %v", fc.calls)
+	}
+
+	if !fc.global[0] {
+		t.Errorf("qos was not applied globally")
+	}
+}
+
+// TestTunerNeverUnlimited checks a backend slower than the target doesn't
+// drop the prefetch to 0, which would remove the limit.
+func TestTunerNeverUnlimited(t *testing.T) {
+
+	fc := &fakeChannel{}
+
+	tuner := NewTuner("test", 100*time.Millisecond, 0, 1000, 0)
+	tuner.qos = fc
+
+	if tuner.Prefetch() != 1 {
+		t.Errorf("bad initial prefetch %d", tuner.Prefetch())
+	}
+
+	tuner.Observe(10 * time.Millisecond)
+
+	if err := tuner.Adjust(); err != nil {
+		t.Fatalf("adjust failed %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		tuner.Observe(time.Second)
+
+		if err := tuner.Adjust(); err != nil {
+			t.Fatalf("adjust failed %s", err)
+		}
+	}
+
+	for _, prefetch := range fc.calls {
+		if prefetch == 0 {
+			t.Errorf("qos set to unlimited %v", fc.calls)
+		}
+	}
+
+	if tuner.Prefetch() != 1 {
+		t.Errorf("bad prefetch %d with latency over target", tuner.Prefetch())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ninjablocks/sphere-go-state-service/prefetch"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streadway/amqp"
+)
+
+// fakeClock only moves when the backend is written to, so the latency the
+// handler measures is exactly the latency of the backend.
+type fakeClock struct {
+	now       time.Time
+	latencies []time.Duration
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+// Set implements backend, each write advances the clock by the next latency.
+func (fc *fakeClock) Set(key, value string) error {
+	fc.now = fc.now.Add(fc.latencies[0])
+	fc.latencies = fc.latencies[1:]
+	return nil
+}
+
+// TestAdaptivePrefetchLoad runs deliveries through the consumer handler while
+// the backend latency shifts by an order of magnitude in both directions and
+// checks the prefetch settles and then stops changing.
+func TestAdaptivePrefetchLoad(t *testing.T) {
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+
+	ss := &stateStore{
+		backend: clock,
+		c:       metrics.NewCounter(),
+		t:       metrics.NewTimer(),
+		clock:   clock.Now,
+	}
+
+	tuner := prefetch.NewTuner("load", 50*time.Millisecond, 1, 5000, 0)
+	handler := ss.stateHandler(tuner.Observe)
+
+	// jitter each write by +/- 10%
+	jitter := []float64{1.0, 1.1, 0.9, 1.05, 0.95}
+
+	phases := []struct {
+		latency  time.Duration
+		expected int
+	}{
+		{250 * time.Microsecond, 200},
+		{2500 * time.Microsecond, 20},
+		{250 * time.Microsecond, 200},
+		{25 * time.Microsecond, 2000},
+	}
+
+	for _, phase := range phases {
+
+		var settled int
+
+		for interval := 0; interval < 30; interval++ {
+
+			deliveries := make(chan amqp.Delivery, 50)
+			done := make(chan error, 1)
+
+			for n := 0; n < 50; n++ {
+				clock.latencies = append(clock.latencies, time.Duration(float64(phase.latency)*jitter[n%len(jitter)]))
+				deliveries <- amqp.Delivery{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{}`)}
+			}
+			close(deliveries)
+
+			handler(deliveries, done)
+
+			if err := tuner.Adjust(); err != nil {
+				t.Fatalf("adjust failed %s", err)
+			}
+
+			if interval == 10 {
+				settled = tuner.Prefetch()
+			}
+
+			if interval > 10 && tuner.Prefetch() != settled {
+				t.Errorf("prefetch still changing at %s latency, %d to %d", phase.latency, settled, tuner.Prefetch())
+				break
+			}
+		}
+
+		if settled < phase.expected*3/4 || settled > phase.expected*5/4 {
+			t.Errorf("bad prefetch %d at %s latency, expected about %d", settled, phase.latency, phase.expected)
+		}
+	}
+}
+
+func TestValidatePrefetch(t *testing.T) {
+
+	valid := [][3]int{{0, 1, 1000}, {100, 1, 1}, {0, 1, 65535}}
+
+	for _, v := range valid {
+		if err := validatePrefetch(v[0], v[1], v[2], true, time.Second, time.Second); err != nil {
+			t.Errorf("unexpected error for %v %s", v, err)
+		}
+	}
+
+	invalid := [][3]int{{-1, 1, 1000}, {0, -1, 1000}, {70000, 1, 1000}, {0, 1, 65536}, {0, 100, 10}, {0, 0, 1000}}
+
+	for _, v := range invalid {
+		if err := validatePrefetch(v[0], v[1], v[2], true, time.Second, time.Second); err == nil {
+			t.Errorf("expected error for %v", v)
+		}
+	}
+
+	// the bounds are unused without adaptive mode
+	if err := validatePrefetch(0, 0, 65535, false, time.Second, time.Second); err != nil {
+		t.Errorf("unexpected error for zero min without adaptive mode %s", err)
+	}
+
+	if err := validatePrefetch(0, 1, 1000, true, 0, time.Second); err == nil {
+		t.Errorf("expected error for zero target")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ninjablocks/sphere-go-state-service/corpus"
+	"github.com/rcrowley/go-metrics"
+)
+
+// runSimulate replays a corpus through the state handler and writes a report
+// which can be diffed between builds.
+func runSimulate() {
+
+	f, err := os.Open(*simulateCorpus)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	out := io.Writer(os.Stdout)
+
+	if *simulateReport != "" {
+		rf, err := os.Create(*simulateReport)
+		if err != nil {
+			panic(err)
+		}
+		defer rf.Close()
+
+		out = rf
+	}
+
+	var mem *memoryBackend
+
+	ss := &stateStore{
+		c: metrics.NewCounter(),
+		t: metrics.NewTimer(),
+	}
+
+	if *simulateRedis != "" {
+		rurl, err := url.Parse(*simulateRedis)
+		if err != nil {
+			panic(err)
+		}
+
+		log.Infof("simulating against scratch redis %s", rurl.Host)
+
+		ss.backend = &redisBackend{pool: newPool(rurl.Host)}
+	} else {
+		mem = newMemoryBackend()
+		ss.backend = mem
+	}
+
+	if err := simulate(corpus.NewReader(f), ss, mem, *simulateSpeed, out); err != nil {
+		log.Errorf("simulation failed: %s", err)
+		os.Exit(1)
+	}
+}
+
+// simulate pushes each record through the handler in order as a replayed
+// write, if speed is non zero the gaps between records are replayed scaled
+// by speed.
+func simulate(r *corpus.Reader, ss *stateStore, mem *memoryBackend, speed float64, out io.Writer) error {
+
+	totals := make(map[outcome]int)
+
+	var last time.Time
+
+	for n := 1; ; n++ {
+
+		rec, err := r.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if berr, ok := err.(*corpus.BadRecordError); ok {
+			log.Warningf("dropping corpus record %d: %s", n, berr)
+			totals[outcomeDropped]++
+
+			fmt.Fprintf(out, "%06d %-8s line=%d\n", n, outcomeDropped, berr.Line)
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("corpus record %d: %s", n, err)
+		}
+
+		if speed > 0 && !last.IsZero() {
+			if gap := rec.Time.Sub(last); gap > 0 {
+				time.Sleep(time.Duration(float64(gap) / speed))
+			}
+		}
+		last = rec.Time
+
+		o := outcomeDropped
+
+		d, err := rec.Delivery()
+
+		if err != nil {
+			log.Warningf("dropping corpus record %d: %s", n, err)
+		} else {
+			o = ss.handle(d, originReplayed)
+		}
+
+		totals[o]++
+
+		fmt.Fprintf(out, "%06d %-8s origin=%-11s %dB %.12s %s\n", n, o, rec.Origin, rec.BodySize, rec.BodyHash, rec.RoutingKey)
+	}
+
+	fmt.Fprintf(out, "# outcomes\n")
+
+	for _, o := range []outcome{outcomeStored, outcomeFiltered, outcomeErrored, outcomeDropped} {
+		fmt.Fprintf(out, "%s %d\n", o, totals[o])
+	}
+
+	if mem != nil {
+		fmt.Fprintf(out, "# state\n")
+
+		for _, key := range mem.Keys() {
+			value, _ := mem.Get(key)
+			fmt.Fprintf(out, "%s %.12s\n", key, corpus.Hash([]byte(value)))
+		}
+	}
+
+	return nil
+}
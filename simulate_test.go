@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ninjablocks/sphere-go-state-service/corpus"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streadway/amqp"
+)
+
+func newSimulateStore() (*stateStore, *memoryBackend) {
+
+	mem := newMemoryBackend()
+
+	return &stateStore{
+		backend: mem,
+		c:       metrics.NewCounter(),
+		t:       metrics.NewTimer(),
+	}, mem
+}
+
+func TestSimulateCorpus(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+
+	w := corpus.NewWriter(buf, false)
+
+	deliveries := []amqp.Delivery{
+		{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{"params":[{"level":0.19}]}`)},
+		{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{"params":[{"level":0.19}]}`), Redelivered: true},
+		{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.media.event.state", Body: bytes.Repeat([]byte("a"), 1<<20)},
+		{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.stat", Body: []byte(`{}`)},
+	}
+
+	for _, d := range deliveries {
+		if err := w.Write(d, string(deliveryOrigin(d))); err != nil {
+			t.Fatalf("failed to write record %s", err)
+		}
+	}
+
+	buf.WriteString("not a record\n")
+
+	ss, mem := newSimulateStore()
+
+	out := &bytes.Buffer{}
+
+	if err := simulate(corpus.NewReader(buf), ss, mem, 0, out); err != nil {
+		t.Fatalf("simulate failed %s", err)
+	}
+
+	report := out.String()
+
+	for _, line := range []string{"stored 3\n", "filtered 1\n", "errored 0\n", "dropped 1\n", "state:123:7511a8ecc5:media ", "state:123:7511a8ecc5:volume "} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report missing %q\n%s", line, report)
+		}
+	}
+
+	if value, _ := mem.Get("state:123:7511a8ecc5:media"); len(value) != 1<<20 {
+		t.Errorf("bad payload size %d", len(value))
+	}
+}
+
+// TestCaptureReplay captures deliveries from the consumer handler and replays
+// them into a fresh store.
+func TestCaptureReplay(t *testing.T) {
+
+	captured := &bytes.Buffer{}
+
+	live, liveMem := newSimulateStore()
+	live.capture = corpus.NewWriter(captured, false)
+
+	deliveries := make(chan amqp.Delivery, 3)
+	deliveries <- amqp.Delivery{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{"params":[{"level":0.19}]}`)}
+	deliveries <- amqp.Delivery{RoutingKey: "123.$cloud.device.b6b984190f.channel.on-off.event.state", Body: []byte(`{"params":[true]}`)}
+	deliveries <- amqp.Delivery{RoutingKey: "bad", Body: []byte(`{}`)}
+	close(deliveries)
+
+	done := make(chan error, 1)
+	live.stateHandler(nil)(deliveries, done)
+	<-done
+
+	replay, replayMem := newSimulateStore()
+
+	out := &bytes.Buffer{}
+
+	if err := simulate(corpus.NewReader(captured), replay, replayMem, 0, out); err != nil {
+		t.Fatalf("simulate failed %s", err)
+	}
+
+	if !strings.Contains(out.String(), "stored 2\nfiltered 1\n") {
+		t.Errorf("bad report\n%s", out)
+	}
+
+	keys := replayMem.Keys()
+
+	if strings.Join(keys, ",") != strings.Join(liveMem.Keys(), ",") || len(keys) != 2 {
+		t.Fatalf("replayed keys %v differ from live keys %v", keys, liveMem.Keys())
+	}
+
+	for _, key := range keys {
+		expected, _ := liveMem.Get(key)
+		if value, _ := replayMem.Get(key); value != expected {
+			t.Errorf("replayed %s = %s expected %s", key, value, expected)
+		}
+	}
+}
+
+func TestSimulateSpeed(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+
+	start := time.Now()
+
+	// three records 100ms apart replayed at 10x should take about 20ms
+	for i := 0; i < 3; i++ {
+		rec := corpus.NewRecord(amqp.Delivery{RoutingKey: "123.$cloud.device.7511a8ecc5.channel.volume.event.state", Body: []byte(`{}`)}, "live", false)
+		rec.Time = start.Add(time.Duration(i) * 100 * time.Millisecond)
+
+		if err := enc.Encode(rec); err != nil {
+			t.Fatalf("failed to write record %s", err)
+		}
+	}
+
+	ss, mem := newSimulateStore()
+
+	began := time.Now()
+
+	if err := simulate(corpus.NewReader(buf), ss, mem, 10, &bytes.Buffer{}); err != nil {
+		t.Fatalf("simulate failed %s", err)
+	}
+
+	if elapsed := time.Since(began); elapsed < 20*time.Millisecond || elapsed > 150*time.Millisecond {
+		t.Errorf("replay at 10x took %s expected about 20ms", elapsed)
+	}
+}